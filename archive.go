@@ -0,0 +1,223 @@
+// Provides transparent access to CollecTor-style descriptor archives, which
+// are commonly distributed as gzip-, xz-, or zstd-compressed files (and,
+// wrapped around all three, as tarballs of individual descriptors).
+
+package zoossh
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Magic bytes used to sniff a file's compression when its extension doesn't
+// already give it away.
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	xzMagic   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// annotatedReader bundles a decompressed stream with the file descriptor it
+// was opened from so both get closed together.
+type annotatedReader struct {
+	io.Reader
+	fd     io.Closer
+	stream io.Reader // the value decompress returned; may itself be fd's bufio.Reader if uncompressed
+}
+
+func (a *annotatedReader) Close() error {
+
+	streamErr := closeStream(a.stream)
+
+	if err := a.fd.Close(); err != nil {
+		return err
+	}
+
+	return streamErr
+}
+
+// closerWithoutError matches decompressors such as *zstd.Decoder, whose
+// Close method -- unlike gzip.Reader's -- takes no error return and so
+// doesn't satisfy io.Closer.
+type closerWithoutError interface {
+	Close()
+}
+
+// closeStream closes stream if the decompressor decompress returned knows
+// how to, handling both io.Closer (gzip.Reader) and the error-less
+// closerWithoutError (zstd.Decoder, which must be closed to release its
+// background goroutines). A plain, uncompressed io.Reader satisfies
+// neither and is left alone.
+func closeStream(stream io.Reader) error {
+
+	if closer, ok := stream.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	if closer, ok := stream.(closerWithoutError); ok {
+		closer.Close()
+	}
+
+	return nil
+}
+
+// decompress wraps r in the decompressor indicated by path's extension,
+// falling back to sniffing the first few bytes of r if the extension is
+// inconclusive.  If neither identifies a compression, r is returned as-is.
+func decompress(path string, r *bufio.Reader) (io.Reader, error) {
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".gz", ".tgz":
+		return gzip.NewReader(r)
+	case ".xz":
+		return xz.NewReader(r)
+	case ".zst":
+		return zstd.NewReader(r)
+	}
+
+	magic, err := r.Peek(6)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(r)
+	case bytes.HasPrefix(magic, xzMagic):
+		return xz.NewReader(r)
+	case bytes.HasPrefix(magic, zstdMagic):
+		return zstd.NewReader(r)
+	default:
+		return r, nil
+	}
+}
+
+// isTarPath reports whether path names a tar archive, compressed or not.
+func isTarPath(path string) bool {
+
+	lower := strings.ToLower(path)
+	if strings.HasSuffix(lower, ".tgz") {
+		return true
+	}
+
+	name := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(
+		lower, ".gz"), ".xz"), ".zst")
+
+	return strings.HasSuffix(name, ".tar")
+}
+
+// OpenAnnotated opens the descriptor file at the given path, transparently
+// decompressing it if it's gzip-, xz-, or zstd-compressed, reads its leading
+// "@type" annotation, and returns a reader positioned right after the
+// annotation line so that the caller can hand it straight to DissectFile or
+// to a ParserFunc.  ParseAnnotated is not a fit for it, as ParseAnnotated
+// reads the "@type" line itself.  The returned reader must be closed by the
+// caller.
+//
+// OpenAnnotated does not descend into tar archives; use WalkTar for those.
+func OpenAnnotated(path string) (io.ReadCloser, *Annotation, error) {
+
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stream, err := decompress(path, bufio.NewReader(fd))
+	if err != nil {
+		fd.Close()
+		return nil, nil, err
+	}
+
+	bufStream := bufio.NewReader(stream)
+	line, err := bufStream.ReadString('\n')
+	if err != nil && err != io.EOF {
+		closeStream(stream)
+		fd.Close()
+		return nil, nil, err
+	}
+
+	annotation, err := parseAnnotationLine(strings.TrimRight(line, "\n"))
+	if err != nil {
+		closeStream(stream)
+		fd.Close()
+		return nil, nil, err
+	}
+
+	return &annotatedReader{Reader: bufStream, fd: fd, stream: stream}, annotation, nil
+}
+
+// WalkTar iterates over the regular-file members of the tar archive exposed
+// by r -- which may itself be wrapped around a gzip/xz/zstd stream -- and
+// invokes fn with each member's name and content in turn.  Walking stops at
+// the first error, either from the tar reader itself or from fn.
+func WalkTar(r io.Reader, fn func(name string, member io.Reader) error) error {
+
+	tarReader := tar.NewReader(r)
+
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if err := fn(header.Name, tarReader); err != nil {
+			return fmt.Errorf("error processing tar member %q: %w", header.Name, err)
+		}
+	}
+}
+
+// OpenAnnotatedTar opens the tarball at the given path -- transparently
+// decompressing it the same way OpenAnnotated does -- and calls fn for every
+// regular-file member in it, having already peeled off and parsed that member's leading
+// "@type" annotation line.
+func OpenAnnotatedTar(path string, fn func(name string, annotation *Annotation, member io.Reader) error) error {
+
+	if !isTarPath(path) {
+		return fmt.Errorf("%q does not look like a tar archive", path)
+	}
+
+	fd, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	stream, err := decompress(path, bufio.NewReader(fd))
+	if err != nil {
+		return err
+	}
+	defer closeStream(stream)
+
+	return WalkTar(stream, func(name string, member io.Reader) error {
+		bufMember := bufio.NewReader(member)
+
+		line, err := bufMember.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		annotation, err := parseAnnotationLine(strings.TrimRight(line, "\n"))
+		if err != nil {
+			return err
+		}
+
+		return fn(name, annotation, bufMember)
+	})
+}
@@ -0,0 +1,314 @@
+package zoossh
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestIsTarPath(t *testing.T) {
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"server-descriptors-2026-01.tar", true},
+		{"server-descriptors-2026-01.tar.gz", true},
+		{"server-descriptors-2026-01.tar.xz", true},
+		{"server-descriptors-2026-01.tar.zst", true},
+		{"server-descriptors-2026-01.TAR.GZ", true},
+		{"server-descriptors-2026-01.tgz", true},
+		{"server-descriptors-2026-01.TGZ", true},
+		{"consensus-2026-01.gz", false},
+		{"consensus-2026-01", false},
+	}
+
+	for _, tt := range tests {
+		if got := isTarPath(tt.path); got != tt.want {
+			t.Errorf("isTarPath(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestDecompressSniffsMagicBytes(t *testing.T) {
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write([]byte("payload")); err != nil {
+		t.Fatalf("gzip.Write: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("gzip.Close: %v", err)
+	}
+
+	// No recognised extension, so decompress must fall back to sniffing
+	// the gzip magic bytes.
+	stream, err := decompress("payload.dat", bufio.NewReader(&buf))
+	if err != nil {
+		t.Fatalf("decompress: %v", err)
+	}
+
+	got, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatalf("io.ReadAll: %v", err)
+	}
+	if string(got) != "payload" {
+		t.Errorf("got %q, want %q", got, "payload")
+	}
+}
+
+func TestDecompressPlainPassesThrough(t *testing.T) {
+
+	stream, err := decompress("plain.txt", bufio.NewReader(strings.NewReader("payload")))
+	if err != nil {
+		t.Fatalf("decompress: %v", err)
+	}
+
+	got, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatalf("io.ReadAll: %v", err)
+	}
+	if string(got) != "payload" {
+		t.Errorf("got %q, want %q", got, "payload")
+	}
+}
+
+func writeGzipFile(t *testing.T, path, content string) {
+
+	t.Helper()
+
+	fd, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("os.Create: %v", err)
+	}
+	defer fd.Close()
+
+	gz := gzip.NewWriter(fd)
+	if _, err := gz.Write([]byte(content)); err != nil {
+		t.Fatalf("gzip.Write: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("gzip.Close: %v", err)
+	}
+}
+
+func TestOpenAnnotated(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "server-descriptor.txt.gz")
+	writeGzipFile(t, path, "@type server-descriptor 1.0\nrouter relay1 1.2.3.4 9001 0 0\n")
+
+	r, annotation, err := OpenAnnotated(path)
+	if err != nil {
+		t.Fatalf("OpenAnnotated: %v", err)
+	}
+	defer r.Close()
+
+	if annotation.Type != "server-descriptor" || annotation.Major != "1" || annotation.Minor != "0" {
+		t.Fatalf("unexpected annotation: %+v", annotation)
+	}
+
+	rest, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("io.ReadAll: %v", err)
+	}
+	if string(rest) != "router relay1 1.2.3.4 9001 0 0\n" {
+		t.Errorf("got %q, want the line following the annotation", rest)
+	}
+
+	if err := r.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
+
+func writeZstdFile(t *testing.T, path, content string) {
+
+	t.Helper()
+
+	fd, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("os.Create: %v", err)
+	}
+	defer fd.Close()
+
+	zw, err := zstd.NewWriter(fd)
+	if err != nil {
+		t.Fatalf("zstd.NewWriter: %v", err)
+	}
+	if _, err := zw.Write([]byte(content)); err != nil {
+		t.Fatalf("zstd.Write: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("zstd.Close: %v", err)
+	}
+}
+
+func TestOpenAnnotatedZstd(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "server-descriptor.txt.zst")
+	writeZstdFile(t, path, "@type server-descriptor 1.0\nrouter relay1 1.2.3.4 9001 0 0\n")
+
+	r, annotation, err := OpenAnnotated(path)
+	if err != nil {
+		t.Fatalf("OpenAnnotated: %v", err)
+	}
+
+	if annotation.Type != "server-descriptor" || annotation.Major != "1" || annotation.Minor != "0" {
+		t.Fatalf("unexpected annotation: %+v", annotation)
+	}
+
+	rest, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("io.ReadAll: %v", err)
+	}
+	if string(rest) != "router relay1 1.2.3.4 9001 0 0\n" {
+		t.Errorf("got %q, want the line following the annotation", rest)
+	}
+
+	ar, ok := r.(*annotatedReader)
+	if !ok {
+		t.Fatalf("expected *annotatedReader, got %T", r)
+	}
+	dec, ok := ar.stream.(*zstd.Decoder)
+	if !ok {
+		t.Fatalf("expected the stream to be a *zstd.Decoder, got %T", ar.stream)
+	}
+
+	if err := r.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+
+	// *zstd.Decoder.Close has no error return and so doesn't satisfy
+	// io.Closer; closeStream must special-case it or the decoder (and its
+	// background goroutines) never actually gets closed. A closed decoder
+	// reports ErrDecoderClosed on further reads.
+	if _, err := dec.Read(make([]byte, 1)); !errors.Is(err, zstd.ErrDecoderClosed) {
+		t.Errorf("expected the zstd decoder to have been closed, got %v", err)
+	}
+}
+
+func TestOpenAnnotatedNonexistentFile(t *testing.T) {
+
+	_, _, err := OpenAnnotated(filepath.Join(t.TempDir(), "does-not-exist.gz"))
+	if err == nil {
+		t.Fatalf("expected an error opening a nonexistent file")
+	}
+}
+
+func buildTar(t *testing.T, members map[string]string) []byte {
+
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	for name, content := range members {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("tw.WriteHeader: %v", err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatalf("tw.Write: %v", err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatalf("tw.Close: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestWalkTar(t *testing.T) {
+
+	raw := buildTar(t, map[string]string{
+		"relay1": "first member\n",
+		"relay2": "second member\n",
+	})
+
+	seen := make(map[string]string)
+	err := WalkTar(bytes.NewReader(raw), func(name string, member io.Reader) error {
+		content, err := io.ReadAll(member)
+		if err != nil {
+			return err
+		}
+		seen[name] = string(content)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WalkTar: %v", err)
+	}
+
+	if len(seen) != 2 || seen["relay1"] != "first member\n" || seen["relay2"] != "second member\n" {
+		t.Fatalf("unexpected members: %+v", seen)
+	}
+}
+
+func TestOpenAnnotatedTar(t *testing.T) {
+
+	raw := buildTar(t, map[string]string{
+		"relay1": "@type server-descriptor 1.0\nrouter relay1 1.2.3.4 9001 0 0\n",
+		"relay2": "@type server-descriptor 1.0\nrouter relay2 5.6.7.8 9001 0 0\n",
+	})
+
+	path := filepath.Join(t.TempDir(), "server-descriptors.tar.gz")
+	fd, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("os.Create: %v", err)
+	}
+	gz := gzip.NewWriter(fd)
+	if _, err := gz.Write(raw); err != nil {
+		t.Fatalf("gzip.Write: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("gzip.Close: %v", err)
+	}
+	if err := fd.Close(); err != nil {
+		t.Fatalf("fd.Close: %v", err)
+	}
+
+	seen := make(map[string]string)
+	err = OpenAnnotatedTar(path, func(name string, annotation *Annotation, member io.Reader) error {
+		if annotation.Type != "server-descriptor" {
+			t.Errorf("unexpected annotation type %q for member %q", annotation.Type, name)
+		}
+		content, err := io.ReadAll(member)
+		if err != nil {
+			return err
+		}
+		seen[name] = string(content)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("OpenAnnotatedTar: %v", err)
+	}
+
+	if seen["relay1"] != "router relay1 1.2.3.4 9001 0 0\n" {
+		t.Errorf("unexpected relay1 content: %q", seen["relay1"])
+	}
+	if seen["relay2"] != "router relay2 5.6.7.8 9001 0 0\n" {
+		t.Errorf("unexpected relay2 content: %q", seen["relay2"])
+	}
+}
+
+func TestOpenAnnotatedTarRejectsNonTarPath(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "not-a-tar.gz")
+	writeGzipFile(t, path, "@type server-descriptor 1.0\n")
+
+	err := OpenAnnotatedTar(path, func(string, *Annotation, io.Reader) error {
+		t.Fatalf("fn should not be called for a non-tar path")
+		return nil
+	})
+	if err == nil {
+		t.Fatalf("expected an error for a non-tar path")
+	}
+}
@@ -0,0 +1,88 @@
+// Provides an annotation-driven parser registry, so that callers don't have
+// to hand-roll a map[Annotation]bool and dispatch on it themselves.
+
+package zoossh
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// ParserFunc parses the document in r -- which must be positioned right
+// after its "@type" annotation line, e.g. by OpenAnnotated -- into a
+// format-specific result.
+type ParserFunc func(io.Reader) (interface{}, error)
+
+// registeredParser associates a ParserFunc with the annotation version range
+// it's able to handle.
+type registeredParser struct {
+	minVer Version
+	maxVer Version
+	fn     ParserFunc
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string][]registeredParser)
+)
+
+// RegisterParser registers fn as responsible for documents whose "@type" is
+// typ and whose version satisfies Version.Satisfies(minVer, maxVer) -- major
+// must match exactly; either of minVer.Minor or maxVer.Minor may be -1 to
+// mean "any".  If multiple registrations for typ overlap, the most recently
+// registered one wins.
+func RegisterParser(typ string, minVer, maxVer Version, fn ParserFunc) {
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	registry[typ] = append(registry[typ], registeredParser{minVer, maxVer, fn})
+}
+
+// ParseAnnotated reads the "@type" annotation off of the first line of r and
+// delegates the rest of r to whichever parser was registered, via
+// RegisterParser, for a compatible type and version.
+func ParseAnnotated(r io.Reader) (interface{}, error) {
+
+	bufReader := bufio.NewReader(r)
+	line, err := bufReader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	annotation, err := parseAnnotationLine(strings.TrimRight(line, "\n"))
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := annotation.Version()
+	if err != nil {
+		return nil, err
+	}
+
+	registryMu.RLock()
+	candidates := registry[annotation.Type]
+	registryMu.RUnlock()
+
+	// Walk candidates newest-first so a later, more specific registration
+	// shadows an earlier, broader one.
+	for i := len(candidates) - 1; i >= 0; i-- {
+		if version.Satisfies(candidates[i].minVer, candidates[i].maxVer) {
+			return candidates[i].fn(bufReader)
+		}
+	}
+
+	return nil, fmt.Errorf("no parser registered for %q version %d.%d", annotation.Type, version.Major, version.Minor)
+}
+
+// The consensus, server-descriptor, extra-info, and microdescriptor parsers
+// that real CollecTor formats need are not part of this tree, so there is
+// nothing here yet to call RegisterParser on their behalf.  Once those
+// parsers land, each should gain an init() that registers itself, e.g.:
+//
+//	func init() {
+//		RegisterParser("network-status-consensus-3", Version{1, 0}, Version{1, -1}, parseConsensus)
+//	}
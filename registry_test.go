@@ -0,0 +1,105 @@
+package zoossh
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestVersionSatisfies(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		v        Version
+		min, max Version
+		want     bool
+	}{
+		{
+			name: "equal major, within range",
+			v:    Version{1, 2},
+			min:  Version{1, 0},
+			max:  Version{1, 5},
+			want: true,
+		},
+		{
+			name: "mismatched major",
+			v:    Version{2, 0},
+			min:  Version{1, 0},
+			max:  Version{1, -1},
+			want: false,
+		},
+		{
+			name: "-1 lower bound accepts anything at or below max",
+			v:    Version{1, 0},
+			min:  Version{1, -1},
+			max:  Version{1, 5},
+			want: true,
+		},
+		{
+			name: "-1 upper bound accepts anything at or above min",
+			v:    Version{1, 100},
+			min:  Version{1, 0},
+			max:  Version{1, -1},
+			want: true,
+		},
+		{
+			name: "below explicit minor lower bound",
+			v:    Version{1, 1},
+			min:  Version{1, 2},
+			max:  Version{1, 5},
+			want: false,
+		},
+		{
+			name: "above explicit minor upper bound",
+			v:    Version{1, 3},
+			min:  Version{1, 0},
+			max:  Version{1, 2},
+			want: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.v.Satisfies(test.min, test.max); got != test.want {
+				t.Errorf("Version%+v.Satisfies(%+v, %+v) = %v, want %v", test.v, test.min, test.max, got, test.want)
+			}
+		})
+	}
+}
+
+// TestParseAnnotatedNewestRegistrationWins exercises the precedence rule
+// documented on RegisterParser: when two registrations for the same type
+// have overlapping version ranges, the most recently registered one wins.
+func TestParseAnnotatedNewestRegistrationWins(t *testing.T) {
+
+	const typ = "test-registry-overlap"
+
+	RegisterParser(typ, Version{1, 0}, Version{1, -1}, func(io.Reader) (interface{}, error) {
+		return "old", nil
+	})
+	RegisterParser(typ, Version{1, 0}, Version{1, -1}, func(io.Reader) (interface{}, error) {
+		return "new", nil
+	})
+
+	result, err := ParseAnnotated(strings.NewReader("@type " + typ + " 1.0\nbody\n"))
+	if err != nil {
+		t.Fatalf("ParseAnnotated: %v", err)
+	}
+	if result != "new" {
+		t.Fatalf("expected the most recently registered parser to win, got %q", result)
+	}
+}
+
+func TestParseAnnotatedNoCompatibleParser(t *testing.T) {
+
+	const typ = "test-registry-no-match"
+
+	RegisterParser(typ, Version{2, 0}, Version{2, -1}, func(io.Reader) (interface{}, error) {
+		return "v2", nil
+	})
+
+	_, err := ParseAnnotated(strings.NewReader("@type " + typ + " 1.0\nbody\n"))
+	if err == nil {
+		t.Fatalf("expected an error when no registered parser's version range matches")
+	}
+}
@@ -4,11 +4,12 @@ package zoossh
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
-	"io/ioutil"
-	"os"
+	"io"
 	"strconv"
 	"strings"
 )
@@ -35,6 +36,71 @@ func (a *Annotation) String() string {
 	return fmt.Sprintf("@type %s %s.%s", a.Type, a.Major, a.Minor)
 }
 
+// Version parses the annotation's Major and Minor fields into a Version.
+func (a *Annotation) Version() (Version, error) {
+
+	major, err := strconv.Atoi(a.Major)
+	if err != nil {
+		return Version{}, fmt.Errorf("invalid major version %q in annotation", a.Major)
+	}
+
+	minor, err := strconv.Atoi(a.Minor)
+	if err != nil {
+		return Version{}, fmt.Errorf("invalid minor version %q in annotation", a.Minor)
+	}
+
+	return Version{major, minor}, nil
+}
+
+// Version represents a MAJOR.MINOR document version, as used in CollecTor
+// "@type" annotations.
+type Version struct {
+	Major int
+	Minor int
+}
+
+// Compare returns -1, 0, or 1 depending on whether v is less than, equal to,
+// or greater than other, comparing Major before Minor.
+func (v Version) Compare(other Version) int {
+
+	if v.Major != other.Major {
+		if v.Major < other.Major {
+			return -1
+		}
+		return 1
+	}
+
+	if v.Minor != other.Minor {
+		if v.Minor < other.Minor {
+			return -1
+		}
+		return 1
+	}
+
+	return 0
+}
+
+// Satisfies reports whether v falls within [min, max].  Both bounds must
+// share v's Major version; either bound's Minor may be -1 to mean
+// "unbounded" in that direction, e.g. Satisfies(Version{1, 0}, Version{1, -1})
+// expresses "1.x, x >= 0".
+func (v Version) Satisfies(min, max Version) bool {
+
+	if v.Major != min.Major || v.Major != max.Major {
+		return false
+	}
+
+	if min.Minor != -1 && v.Minor < min.Minor {
+		return false
+	}
+
+	if max.Minor != -1 && v.Minor > max.Minor {
+		return false
+	}
+
+	return true
+}
+
 // Equals checks whether the two given annotations have the same content.
 func (a *Annotation) Equals(b *Annotation) bool {
 
@@ -59,109 +125,174 @@ func Base64ToString(encoded string) (string, error) {
 	return hex.EncodeToString(decoded), nil
 }
 
-// GetAnnotation obtains and returns the given file's annotation.  If anything
-// fails in the process, an error string is returned.
-func GetAnnotation(fileName string) (*Annotation, error) {
-
-	fd, err := os.Open(fileName)
-	if err != nil {
-		return nil, err
-	}
-	defer fd.Close()
-
-	// Fetch the file's first line which should be the annotation.
-
-	scanner := bufio.NewScanner(fd)
-	scanner.Scan()
-	annotationText := scanner.Text()
-
-	annotation := new(Annotation)
+// parseAnnotationLine parses a single "@type TYPE MAJOR.MINOR" line into an
+// Annotation.  It is the common ground between GetAnnotation, CheckAnnotation,
+// and OpenAnnotated.
+func parseAnnotationLine(line string) (*Annotation, error) {
 
 	// We expect "@type TYPE VERSION".
-	words := strings.Split(annotationText, " ")
+	words := strings.Split(line, " ")
 	if len(words) != 3 {
-		return nil, fmt.Errorf("Could not parse file annotation for \"%s\".", fileName)
+		return nil, fmt.Errorf("Could not parse file annotation: %q", line)
 	}
-	annotation.Type = words[1]
 
 	// We expect "MAJOR.MINOR".
 	version := strings.Split(words[2], ".")
 	if len(version) != 2 {
-		return nil, fmt.Errorf("Could not parse file annotation for \"%s\".", fileName)
+		return nil, fmt.Errorf("Could not parse file annotation: %q", line)
 	}
-	annotation.Major = version[0]
-	annotation.Minor = version[1]
 
-	return annotation, nil
+	return &Annotation{words[1], version[0], version[1]}, nil
 }
 
-// Checks the type annotation in the given file.  The Annotation struct
-// determines what we want to see in the file.  If we don't see the expected
-// annotation, an error string is returned.
-func CheckAnnotation(fd *os.File, expected map[Annotation]bool) error {
+// GetAnnotation obtains and returns the annotation found in the given
+// reader's first line.  If anything fails in the process, an error string is
+// returned.
+func GetAnnotation(r io.Reader) (*Annotation, error) {
+
+	// Fetch the first line which should be the annotation.
+	scanner := bufio.NewScanner(r)
+	scanner.Scan()
+
+	return parseAnnotationLine(scanner.Text())
+}
+
+// Checks the type annotation found in the given reader's first line.  The
+// Annotation struct determines what we want to see in the file.  If we don't
+// see the expected annotation, an error string is returned.
+func CheckAnnotation(r io.Reader, expected map[Annotation]bool) error {
 
 	// The annotation is placed in the first line of the file.  See the
 	// following URL for details:
 	// <https://collector.torproject.org/formats.html>
-	scanner := bufio.NewScanner(fd)
+	scanner := bufio.NewScanner(r)
 	scanner.Scan()
-	annotation := scanner.Text()
-
-	invalidFormat := fmt.Errorf("Unexpected file annotation: %s", annotation)
-
-	// We expect "@type TYPE VERSION".
-	words := strings.Split(annotation, " ")
-	if len(words) != 3 {
-		return invalidFormat
-	}
+	line := scanner.Text()
 
-	// We expect "MAJOR.MINOR".
-	version := strings.Split(words[2], ".")
-	if len(version) != 2 {
-		return invalidFormat
+	observed, err := parseAnnotationLine(line)
+	if err != nil {
+		return fmt.Errorf("Unexpected file annotation: %s", line)
 	}
-	observed := Annotation{words[1], version[0], version[1]}
 
 	for annotation, _ := range expected {
 		// We support the observed annotation.
-		if annotation.Equals(&observed) {
+		if annotation.Equals(observed) {
 			return nil
 		}
 	}
 
-	return invalidFormat
+	return fmt.Errorf("Unexpected file annotation: %s", line)
 }
 
-// Dissects the given file into string chunks as specified by the given
-// delimiter.  The resulting string chunks are then written to the given queue
-// where the receiving end parses them.
-func DissectFile(fd *os.File, delim Delimiter, queue chan QueueUnit) {
+// DissectOption configures the behaviour of DissectFile.
+type DissectOption func(*dissectConfig)
 
-	defer close(queue)
+type dissectConfig struct {
+	maxTokenSize int
+}
 
-	blurb, err := ioutil.ReadAll(fd)
-	if err != nil {
-		queue <- QueueUnit{"", err}
+// SetMaxTokenSize overrides the maximum size of a single blurb that
+// DissectFile is willing to buffer, mirroring bufio.Scanner.Buffer.  It is
+// needed because individual server descriptors can exceed the default
+// bufio.MaxScanTokenSize (64 KiB).
+func SetMaxTokenSize(size int) DissectOption {
+
+	return func(cfg *dissectConfig) {
+		cfg.maxTokenSize = size
 	}
+}
 
-	rawContent := string(blurb)
+// splitAtDelimiter returns a bufio.SplitFunc that emits a token for every
+// occurrence of delim.Pattern it encounters, mirroring the slicing semantics
+// that DissectFile used to implement by hand: a token ends delim.Offset
+// bytes past the start of the match, and any trailing data that is not
+// followed by another match is discarded.
+func splitAtDelimiter(delim Delimiter) bufio.SplitFunc {
+
+	pattern := []byte(delim.Pattern)
+
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+
+		if index := bytes.Index(data, pattern); index >= 0 {
+			end := index + int(delim.Offset)
+
+			// The match was found, but we haven't buffered enough of the
+			// blurb yet to include the full offset.  Ask for more data
+			// unless we're already at the end of the stream.
+			if end > len(data) {
+				if atEOF {
+					return len(data), data, nil
+				}
+				return 0, nil, nil
+			}
+
+			// end can only be 0 when index is 0 too (Offset's zero value
+			// landing the match at the very start of data), which happens
+			// when a previous call already cut the token right before this
+			// same match. Returning a zero advance here would hand the
+			// scanner back the exact same match next time -- a token that's
+			// non-nil but zero-length, which slips past bufio.Scanner's
+			// (0, nil, nil) infinite-loop guard and spins forever. Advance
+			// by one byte, just enough that the next search can no longer
+			// find this exact occurrence, so the untaken pattern bytes
+			// still reach the next token instead of being silently dropped.
+			advance = end
+			if advance == 0 {
+				advance = 1
+			}
+
+			return advance, data[:end], nil
+		}
 
-	for {
-		// Jump to the end of the next string blurb.
-		position := strings.Index(rawContent, delim.Pattern)
-		if position == -1 {
-			break
+		// No more delimiters are coming; discard whatever is left, just
+		// like the original implementation did.  Consuming it without a
+		// token ends the scan without emitting a trailing empty blurb.
+		if atEOF {
+			return len(data), nil, nil
 		}
-		position += int(delim.Offset)
 
-		if delim.Skip > 0 {
-			delim.Skip -= 1
-		} else {
-			queue <- QueueUnit{rawContent[:position], nil}
+		return 0, nil, nil
+	}
+}
+
+// DissectFile dissects the content read from the given reader into string
+// chunks as specified by the given delimiter, streaming them to the given
+// queue as soon as each one is found so that callers never have to hold more
+// than one blurb in memory at a time.  The receiving end parses the blurbs
+// off of the queue.  Dissection stops early, with the context's error
+// reported on the queue, if ctx is cancelled.
+func DissectFile(ctx context.Context, r io.Reader, delim Delimiter, queue chan QueueUnit, opts ...DissectOption) {
+
+	defer close(queue)
+
+	cfg := dissectConfig{maxTokenSize: bufio.MaxScanTokenSize}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), cfg.maxTokenSize)
+	scanner.Split(splitAtDelimiter(delim))
+
+	skip := delim.Skip
+	for scanner.Scan() {
+		select {
+		case <-ctx.Done():
+			queue <- QueueUnit{"", ctx.Err()}
+			return
+		default:
+		}
+
+		if skip > 0 {
+			skip -= 1
+			continue
 		}
 
-		// Point to the beginning of the next string blurb.
-		rawContent = rawContent[position:]
+		queue <- QueueUnit{scanner.Text(), nil}
+	}
+
+	if err := scanner.Err(); err != nil {
+		queue <- QueueUnit{"", err}
 	}
 }
 
@@ -0,0 +1,141 @@
+package zoossh
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// drainDissectFile runs DissectFile on input and collects every QueueUnit it
+// emits, failing the test instead of hanging if dissection doesn't finish
+// within a few seconds.
+func drainDissectFile(t *testing.T, ctx context.Context, r *strings.Reader, delim Delimiter, opts ...DissectOption) []QueueUnit {
+
+	t.Helper()
+
+	queue := make(chan QueueUnit)
+	go DissectFile(ctx, r, delim, queue, opts...)
+
+	var units []QueueUnit
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case unit, ok := <-queue:
+			if !ok {
+				return units
+			}
+			units = append(units, unit)
+		case <-timeout:
+			t.Fatalf("DissectFile did not finish within the timeout; got %d units so far", len(units))
+		}
+	}
+}
+
+func TestDissectFileZeroOffsetDoesNotHang(t *testing.T) {
+
+	// Offset is its zero value here, which used to leave the matched
+	// pattern unconsumed and spin bufio.Scanner forever re-matching the
+	// same position (see splitAtDelimiter). The one-byte skip needed to
+	// break that loop shows up as an empty blurb between real ones, but
+	// must never eat into a real blurb's content -- in particular the
+	// "r line2" blurb must keep its "r " prefix intact.  The trailing
+	// "r line3" record has no delimiter after it and is discarded.
+	r := strings.NewReader("r line1\nmore1\nr line2\nmore2\nr line3\n")
+	delim := Delimiter{Pattern: "\nr ", Offset: 0}
+
+	units := drainDissectFile(t, context.Background(), r, delim)
+
+	var blurbs []string
+	for _, unit := range units {
+		if unit.Err != nil {
+			t.Fatalf("unexpected error in QueueUnit: %v", unit.Err)
+		}
+		blurbs = append(blurbs, unit.Blurb)
+	}
+
+	want := []string{"r line1\nmore1", "", "r line2\nmore2", ""}
+	if len(blurbs) != len(want) {
+		t.Fatalf("got %d blurbs %q, want %d %q", len(blurbs), blurbs, len(want), want)
+	}
+	for i := range want {
+		if blurbs[i] != want[i] {
+			t.Errorf("blurb %d = %q, want %q", i, blurbs[i], want[i])
+		}
+	}
+}
+
+func TestDissectFileMultiRecord(t *testing.T) {
+
+	// No trailing delimiter after the last record, so it is discarded per
+	// splitAtDelimiter's documented semantics.
+	r := strings.NewReader("r line1\nmore1\nr line2\nmore2\nr line3")
+	delim := Delimiter{Pattern: "\n", Offset: 1}
+
+	units := drainDissectFile(t, context.Background(), r, delim)
+
+	var blurbs []string
+	for _, unit := range units {
+		if unit.Err != nil {
+			t.Fatalf("unexpected error in QueueUnit: %v", unit.Err)
+		}
+		blurbs = append(blurbs, unit.Blurb)
+	}
+
+	// The trailing "r line3" has no delimiter after it, so it's discarded
+	// per splitAtDelimiter's documented semantics, and no empty blurb
+	// takes its place.
+	want := []string{"r line1\n", "more1\n", "r line2\n", "more2\n"}
+	if len(blurbs) != len(want) {
+		t.Fatalf("got %d blurbs %q, want %d %q", len(blurbs), blurbs, len(want), want)
+	}
+	for i := range want {
+		if blurbs[i] != want[i] {
+			t.Errorf("blurb %d = %q, want %q", i, blurbs[i], want[i])
+		}
+	}
+}
+
+func TestDissectFileMaxTokenSize(t *testing.T) {
+
+	big := strings.Repeat("x", 128*1024)
+	r := strings.NewReader(big + "\n")
+	delim := Delimiter{Pattern: "\n", Offset: 1}
+
+	t.Run("default buffer rejects an oversized blurb", func(t *testing.T) {
+		units := drainDissectFile(t, context.Background(), strings.NewReader(big+"\n"), delim)
+
+		var sawErr bool
+		for _, unit := range units {
+			if unit.Err != nil {
+				sawErr = true
+			}
+		}
+		if !sawErr {
+			t.Fatalf("expected an error for a blurb exceeding the default scanner buffer")
+		}
+	})
+
+	t.Run("SetMaxTokenSize allows it through", func(t *testing.T) {
+		units := drainDissectFile(t, context.Background(), r, delim, SetMaxTokenSize(256*1024))
+
+		if len(units) == 0 || units[0].Err != nil || units[0].Blurb != big+"\n" {
+			t.Fatalf("expected the oversized blurb to come through unchanged, got %+v", units)
+		}
+	})
+}
+
+func TestDissectFileContextCancellation(t *testing.T) {
+
+	r := strings.NewReader("r line1\nr line2\nr line3\n")
+	delim := Delimiter{Pattern: "\n", Offset: 1}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	units := drainDissectFile(t, ctx, r, delim)
+
+	if len(units) != 1 || units[0].Err != context.Canceled {
+		t.Fatalf("expected a single QueueUnit carrying context.Canceled, got %+v", units)
+	}
+}
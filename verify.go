@@ -0,0 +1,307 @@
+// Provides signature verification for consensus and server-descriptor
+// documents, so that callers don't have to trust an unauthenticated parse.
+
+package zoossh
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AuthorityKey holds the public key a directory authority uses to sign
+// consensuses and votes.
+type AuthorityKey struct {
+	Nickname   string
+	Identity   string // hex-encoded SHA-1 fingerprint of the authority's identity key
+	SigningKey *rsa.PublicKey
+}
+
+// SignatureStatus reports the outcome of checking a single signature block.
+type SignatureStatus struct {
+	Identity string // hex-encoded SHA-1 fingerprint named in the signature block
+	Err      error  // nil if the signature validated
+}
+
+var (
+	ErrMissingAuthorityKey = errors.New("no known authority matches this signature's identity")
+	ErrBadSignature        = errors.New("signature does not validate")
+	ErrStaleDocument       = errors.New("document's valid-until has passed")
+)
+
+// VerifyResult reports how many of a document's signatures validated, out of
+// how many were required, plus the per-signature detail behind that count.
+type VerifyResult struct {
+	Signatures []SignatureStatus
+	Valid      int
+	Required   int
+}
+
+// Satisfied reports whether enough signatures validated for the document to
+// be trusted.
+func (r VerifyResult) Satisfied() bool {
+
+	return r.Valid >= r.Required
+}
+
+// pemToBytes strips a "-----BEGIN x-----" / "-----END x-----" wrapper and
+// decodes the Base64 payload in between, in the same spirit as
+// Base64ToString.
+func pemToBytes(block string) ([]byte, error) {
+
+	start := strings.Index(block, "-----BEGIN")
+	if start == -1 {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+	start = strings.IndexByte(block[start:], '\n') + start + 1
+
+	end := strings.Index(block, "-----END")
+	if end == -1 || end < start {
+		return nil, fmt.Errorf("unterminated PEM block")
+	}
+
+	encoded := strings.ReplaceAll(block[start:end], "\n", "")
+	if rem := len(encoded) % 4; rem != 0 {
+		encoded += strings.Repeat("=", 4-rem)
+	}
+
+	return base64.StdEncoding.DecodeString(encoded)
+}
+
+// signedPortion returns the slice of doc that begins at startMarker and
+// extends through the end of endMarker itself -- the span that consensus
+// and server-descriptor signatures are computed over, per dir-spec.txt
+// §3.4.1 and §2.1 respectively. Callers must pass endMarker as the exact
+// literal the signed portion stops after: for a consensus that is
+// "directory-signature " (every authority signs this same common prefix,
+// not the per-signer identity/key-digest fields that follow it on that
+// line); for a server descriptor it is "router-signature\n", since that
+// line carries no per-signer fields to exclude.
+func signedPortion(doc []byte, startMarker, endMarker string) ([]byte, error) {
+
+	start := bytes.Index(doc, []byte(startMarker))
+	if start == -1 {
+		return nil, fmt.Errorf("could not find %q", startMarker)
+	}
+
+	end := bytes.Index(doc[start:], []byte(endMarker))
+	if end == -1 {
+		return nil, fmt.Errorf("could not find %q", endMarker)
+	}
+	end += start + len(endMarker)
+
+	return doc[start:end], nil
+}
+
+// validUntil extracts and parses a document's "valid-until" line.
+func validUntil(doc []byte) (time.Time, error) {
+
+	marker := []byte("valid-until ")
+	start := bytes.Index(doc, marker)
+	if start == -1 {
+		return time.Time{}, fmt.Errorf("could not find %q", "valid-until")
+	}
+	start += len(marker)
+
+	lineEnd := bytes.IndexByte(doc[start:], '\n')
+	if lineEnd == -1 {
+		lineEnd = len(doc) - start
+	}
+
+	return time.Parse("2006-01-02 15:04:05", string(doc[start:start+lineEnd]))
+}
+
+// digestFor returns the digest and crypto.Hash identifier used to verify a
+// signature computed under the given algorithm name, as named by a
+// "directory-signature" line's optional Algorithm field (dir-spec.txt
+// §3.4.1).  An empty algorithm means the original, implicit sha1 signature.
+func digestFor(algorithm string, signed []byte) ([]byte, crypto.Hash, error) {
+
+	switch algorithm {
+	case "", "sha1":
+		digest := sha1.Sum(signed)
+		return digest[:], crypto.SHA1, nil
+	case "sha256":
+		digest := sha256.Sum256(signed)
+		return digest[:], crypto.SHA256, nil
+	default:
+		return nil, 0, fmt.Errorf("unsupported signature algorithm %q", algorithm)
+	}
+}
+
+// verifySignatures walks every "directory-signature" [Algorithm] Identity
+// SigningKeyDigest\n-----BEGIN SIGNATURE-----\n...\n-----END SIGNATURE-----"
+// block in doc, matches Identity against authorities, and checks the
+// enclosed signature against the digest of signed appropriate for
+// Algorithm -- sha1 if omitted, sha256 if given (dir-spec.txt §3.4.1;
+// consensuses carry one of each per authority).
+func verifySignatures(doc, signed []byte, authorities []AuthorityKey, required int) VerifyResult {
+
+	byIdentity := make(map[string]*rsa.PublicKey, len(authorities))
+	for _, a := range authorities {
+		byIdentity[strings.ToLower(a.Identity)] = a.SigningKey
+	}
+
+	result := VerifyResult{Required: required}
+
+	rest := doc
+	for {
+		index := bytes.Index(rest, []byte("directory-signature "))
+		if index == -1 {
+			break
+		}
+		rest = rest[index:]
+
+		lineEnd := bytes.IndexByte(rest, '\n')
+		if lineEnd == -1 {
+			break
+		}
+
+		// "directory-signature" [SP Algorithm] SP Identity SP
+		// SigningKeyDigest -- Algorithm is only present for the
+		// newer, non-sha1 signature lines.
+		fields := strings.Fields(string(rest[len("directory-signature "):lineEnd]))
+
+		var algorithm, identity string
+		switch len(fields) {
+		case 2:
+			algorithm, identity = "sha1", fields[0]
+		case 3:
+			algorithm, identity = strings.ToLower(fields[0]), fields[1]
+		default:
+			rest = rest[lineEnd+1:]
+			continue
+		}
+		identity = strings.ToLower(identity)
+
+		// Scope the BEGIN/END SIGNATURE search to this block alone, i.e.
+		// no further than the next "directory-signature" line, so a
+		// malformed block can never "borrow" a later, well-formed
+		// block's markers.
+		block := rest
+		if next := bytes.Index(rest[lineEnd+1:], []byte("directory-signature ")); next != -1 {
+			block = rest[:lineEnd+1+next]
+		}
+
+		sigStart := bytes.Index(block, []byte("-----BEGIN SIGNATURE-----"))
+		sigEnd := bytes.Index(block, []byte("-----END SIGNATURE-----"))
+		if sigStart == -1 || sigEnd == -1 || sigEnd < sigStart {
+			// This block's signature markers are missing or malformed;
+			// skip past its "directory-signature" line and keep scanning
+			// in case a later, well-formed block still follows.
+			rest = rest[lineEnd+1:]
+			continue
+		}
+		sigEnd += len("-----END SIGNATURE-----")
+
+		status := SignatureStatus{Identity: identity}
+
+		if digest, hash, err := digestFor(algorithm, signed); err != nil {
+			status.Err = err
+		} else if key, ok := byIdentity[identity]; !ok {
+			status.Err = ErrMissingAuthorityKey
+		} else if sig, err := pemToBytes(string(rest[sigStart:sigEnd])); err != nil {
+			status.Err = err
+		} else if err := rsa.VerifyPKCS1v15(key, hash, digest, sig); err != nil {
+			status.Err = fmt.Errorf("%w: %v", ErrBadSignature, err)
+		}
+
+		if status.Err == nil {
+			result.Valid++
+		}
+		result.Signatures = append(result.Signatures, status)
+
+		rest = rest[sigEnd:]
+	}
+
+	return result
+}
+
+// VerifyConsensus checks doc's "directory-signature" blocks against the
+// given authorities.  At least half plus one of them must validate for the
+// consensus to be trusted, per dir-spec.txt §3.4.1.
+func VerifyConsensus(doc []byte, authorities []AuthorityKey) (VerifyResult, error) {
+
+	if until, err := validUntil(doc); err == nil && time.Now().After(until) {
+		return VerifyResult{}, ErrStaleDocument
+	}
+
+	signed, err := signedPortion(doc, "network-status-version", "directory-signature ")
+	if err != nil {
+		return VerifyResult{}, err
+	}
+
+	return verifySignatures(doc, signed, authorities, len(authorities)/2+1), nil
+}
+
+// VerifyServerDescriptor checks a server descriptor's "router-signature"
+// block.  Server descriptors are self-signed by the relay, so callers pass
+// the single AuthorityKey built from the descriptor's own "signing-key".
+func VerifyServerDescriptor(doc []byte, relayKey AuthorityKey) (VerifyResult, error) {
+
+	signed, err := signedPortion(doc, "router ", "router-signature\n")
+	if err != nil {
+		return VerifyResult{}, err
+	}
+
+	// Server descriptors predate the Algorithm field and are always
+	// signed with sha1 (dir-spec.txt §2.1).
+	digest, hash, err := digestFor("sha1", signed)
+	if err != nil {
+		return VerifyResult{}, err
+	}
+
+	sigStart := bytes.Index(doc, []byte("-----BEGIN SIGNATURE-----"))
+	sigEnd := bytes.Index(doc, []byte("-----END SIGNATURE-----"))
+	if sigStart == -1 || sigEnd == -1 || sigEnd < sigStart {
+		return VerifyResult{}, fmt.Errorf("could not find router-signature block")
+	}
+	sigEnd += len("-----END SIGNATURE-----")
+
+	status := SignatureStatus{Identity: relayKey.Identity}
+	if sig, err := pemToBytes(string(doc[sigStart:sigEnd])); err != nil {
+		status.Err = err
+	} else if err := rsa.VerifyPKCS1v15(relayKey.SigningKey, hash, digest, sig); err != nil {
+		status.Err = fmt.Errorf("%w: %v", ErrBadSignature, err)
+	}
+
+	result := VerifyResult{Required: 1, Signatures: []SignatureStatus{status}}
+	if status.Err == nil {
+		result.Valid = 1
+	}
+
+	return result, nil
+}
+
+// VerifyEd25519Cert checks the Ed25519 certificate embedded in an
+// "identity-ed25519", "master-key-ed25519", or "router-sig-ed25519" block
+// (cert-spec.txt §2.1: everything but the trailing 64 signature bytes is
+// what got signed) against the key that's expected to have issued it.
+func VerifyEd25519Cert(certBlock string, signingKey ed25519.PublicKey) error {
+
+	raw, err := pemToBytes(certBlock)
+	if err != nil {
+		return err
+	}
+
+	if len(raw) <= ed25519.SignatureSize {
+		return fmt.Errorf("certificate too short to contain a signature")
+	}
+
+	signed := raw[:len(raw)-ed25519.SignatureSize]
+	sig := raw[len(raw)-ed25519.SignatureSize:]
+
+	if !ed25519.Verify(signingKey, signed, sig) {
+		return ErrBadSignature
+	}
+
+	return nil
+}
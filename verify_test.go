@@ -0,0 +1,274 @@
+package zoossh
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func mustRSAKey(t *testing.T) *rsa.PrivateKey {
+
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey: %v", err)
+	}
+
+	return key
+}
+
+func pemBlock(kind string, raw []byte) string {
+
+	encoded := base64.StdEncoding.EncodeToString(raw)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "-----BEGIN %s-----\n", kind)
+	for len(encoded) > 64 {
+		b.WriteString(encoded[:64])
+		b.WriteByte('\n')
+		encoded = encoded[64:]
+	}
+	b.WriteString(encoded)
+	fmt.Fprintf(&b, "\n-----END %s-----\n", kind)
+
+	return b.String()
+}
+
+// signRSA signs signed under the given hash algorithm, returning the PEM
+// "SIGNATURE" block that verifySignatures expects to find.
+func signRSA(t *testing.T, key *rsa.PrivateKey, hash crypto.Hash, signed []byte) string {
+
+	t.Helper()
+
+	var digest []byte
+	switch hash {
+	case crypto.SHA1:
+		sum := sha1.Sum(signed)
+		digest = sum[:]
+	case crypto.SHA256:
+		sum := sha256.Sum256(signed)
+		digest = sum[:]
+	default:
+		t.Fatalf("unsupported hash in test: %v", hash)
+	}
+
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, hash, digest)
+	if err != nil {
+		t.Fatalf("rsa.SignPKCS1v15: %v", err)
+	}
+
+	return pemBlock("SIGNATURE", sig)
+}
+
+func TestVerifyConsensus(t *testing.T) {
+
+	key := mustRSAKey(t)
+	const identity = "abcd1234abcd1234abcd1234abcd1234abcd1234"
+
+	// Every authority signs this exact common prefix, ending right after
+	// the literal "directory-signature " token -- none of the per-signer
+	// identity/signing-key-digest fields that follow it on the line are
+	// part of the signed content (dir-spec.txt §3.4.1).
+	commonPrefix := "network-status-version 3\n" +
+		"valid-until 2999-01-01 00:00:00\n" +
+		"r relay1 base64digest base64digest2 2026-01-01 00:00:00 1.2.3.4 9001 0\n" +
+		"directory-signature "
+
+	sha1Sig := signRSA(t, key, crypto.SHA1, []byte(commonPrefix))
+	sha256Sig := signRSA(t, key, crypto.SHA256, []byte(commonPrefix))
+
+	authorities := []AuthorityKey{{Nickname: "auth1", Identity: identity, SigningKey: &key.PublicKey}}
+
+	t.Run("sha1 and sha256 both validate", func(t *testing.T) {
+		doc := commonPrefix + identity + " keydigest\n" + sha1Sig +
+			"directory-signature sha256 " + identity + " keydigest\n" + sha256Sig
+
+		result, err := VerifyConsensus([]byte(doc), authorities)
+		if err != nil {
+			t.Fatalf("VerifyConsensus: %v", err)
+		}
+		if len(result.Signatures) != 2 {
+			t.Fatalf("expected 2 signature blocks, got %d", len(result.Signatures))
+		}
+		if result.Valid != 2 {
+			t.Fatalf("expected both signatures to validate, got %d valid", result.Valid)
+		}
+		if !result.Satisfied() {
+			t.Fatalf("expected result to be satisfied")
+		}
+	})
+
+	t.Run("multiple authorities each validate their own signature", func(t *testing.T) {
+		key2 := mustRSAKey(t)
+		const identity2 = "1111222233334444555566667777888899990000"
+
+		sig1 := signRSA(t, key, crypto.SHA1, []byte(commonPrefix))
+		sig2 := signRSA(t, key2, crypto.SHA1, []byte(commonPrefix))
+
+		doc := commonPrefix + identity + " keydigest1\n" + sig1 +
+			"directory-signature " + identity2 + " keydigest2\n" + sig2
+
+		multi := []AuthorityKey{
+			{Nickname: "auth1", Identity: identity, SigningKey: &key.PublicKey},
+			{Nickname: "auth2", Identity: identity2, SigningKey: &key2.PublicKey},
+		}
+
+		result, err := VerifyConsensus([]byte(doc), multi)
+		if err != nil {
+			t.Fatalf("VerifyConsensus: %v", err)
+		}
+		if len(result.Signatures) != 2 {
+			t.Fatalf("expected 2 signature blocks, got %d", len(result.Signatures))
+		}
+		if result.Valid != 2 {
+			t.Fatalf("expected both authorities' signatures to validate, got %d valid", result.Valid)
+		}
+		if !result.Satisfied() {
+			t.Fatalf("expected result to be satisfied")
+		}
+	})
+
+	t.Run("missing authority key", func(t *testing.T) {
+		doc := commonPrefix + identity + " keydigest\n" + sha1Sig
+
+		result, err := VerifyConsensus([]byte(doc), nil)
+		if err != nil {
+			t.Fatalf("VerifyConsensus: %v", err)
+		}
+		if len(result.Signatures) != 1 || result.Signatures[0].Err != ErrMissingAuthorityKey {
+			t.Fatalf("expected ErrMissingAuthorityKey, got %+v", result.Signatures)
+		}
+	})
+
+	t.Run("bad signature", func(t *testing.T) {
+		// A signature computed over different content than commonPrefix
+		// should not validate against it.
+		otherSig := signRSA(t, key, crypto.SHA1, []byte(commonPrefix+"tamper"))
+		doc := commonPrefix + identity + " keydigest\n" + otherSig
+
+		result, err := VerifyConsensus([]byte(doc), authorities)
+		if err != nil {
+			t.Fatalf("VerifyConsensus: %v", err)
+		}
+		if len(result.Signatures) != 1 {
+			t.Fatalf("expected 1 signature block, got %d", len(result.Signatures))
+		}
+		if !errors.Is(result.Signatures[0].Err, ErrBadSignature) {
+			t.Fatalf("expected ErrBadSignature, got %v", result.Signatures[0].Err)
+		}
+	})
+
+	t.Run("malformed block followed by a good one", func(t *testing.T) {
+		// The first block's signature markers are missing entirely; the
+		// second authority's well-formed, validly-signed block must still
+		// be found and counted rather than being swallowed by the first
+		// block's (scoped) search for markers.
+		doc := commonPrefix + identity + " keydigest\n" +
+			"directory-signature sha256 " + identity + " keydigest\n" + sha256Sig
+
+		result, err := VerifyConsensus([]byte(doc), authorities)
+		if err != nil {
+			t.Fatalf("VerifyConsensus: %v", err)
+		}
+		if len(result.Signatures) != 1 {
+			t.Fatalf("expected only the well-formed block to be reported, got %+v", result.Signatures)
+		}
+		if result.Signatures[0].Identity != identity || result.Signatures[0].Err != nil {
+			t.Fatalf("expected the good authority's signature to validate, got %+v", result.Signatures[0])
+		}
+		if result.Valid != 1 {
+			t.Fatalf("expected 1 valid signature, got %d", result.Valid)
+		}
+	})
+
+	t.Run("stale consensus", func(t *testing.T) {
+		staleHeader := strings.Replace(commonPrefix, "valid-until 2999-01-01 00:00:00", "valid-until 2000-01-01 00:00:00", 1)
+		staleSig := signRSA(t, key, crypto.SHA1, []byte(staleHeader))
+		doc := staleHeader + identity + " keydigest\n" + staleSig
+
+		_, err := VerifyConsensus([]byte(doc), authorities)
+		if !errors.Is(err, ErrStaleDocument) {
+			t.Fatalf("expected ErrStaleDocument, got %v", err)
+		}
+	})
+}
+
+func TestVerifyServerDescriptor(t *testing.T) {
+
+	key := mustRSAKey(t)
+
+	header := "router relay1 1.2.3.4 9001 0 0\n" +
+		"platform Tor\n" +
+		"router-signature\n"
+
+	sig := signRSA(t, key, crypto.SHA1, []byte(header))
+	relayKey := AuthorityKey{Nickname: "relay1", Identity: "deadbeef", SigningKey: &key.PublicKey}
+
+	t.Run("valid signature", func(t *testing.T) {
+		doc := header + sig
+
+		result, err := VerifyServerDescriptor([]byte(doc), relayKey)
+		if err != nil {
+			t.Fatalf("VerifyServerDescriptor: %v", err)
+		}
+		if !result.Satisfied() {
+			t.Fatalf("expected valid signature to satisfy the result")
+		}
+	})
+
+	t.Run("bad signature", func(t *testing.T) {
+		otherSig := signRSA(t, key, crypto.SHA1, []byte(header+"tamper"))
+		doc := header + otherSig
+
+		result, err := VerifyServerDescriptor([]byte(doc), relayKey)
+		if err != nil {
+			t.Fatalf("VerifyServerDescriptor: %v", err)
+		}
+		if result.Satisfied() {
+			t.Fatalf("expected tampered signature to not satisfy the result")
+		}
+		if !errors.Is(result.Signatures[0].Err, ErrBadSignature) {
+			t.Fatalf("expected ErrBadSignature, got %v", result.Signatures[0].Err)
+		}
+	})
+}
+
+func TestVerifyEd25519Cert(t *testing.T) {
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey: %v", err)
+	}
+
+	certBody := []byte{0x01, 0x04, 0x00, 0x00, 0x00, 0x00}
+	sig := ed25519.Sign(priv, certBody)
+	cert := pemBlock("ED25519 CERT", append(append([]byte{}, certBody...), sig...))
+
+	t.Run("valid certificate", func(t *testing.T) {
+		if err := VerifyEd25519Cert(cert, pub); err != nil {
+			t.Fatalf("VerifyEd25519Cert: %v", err)
+		}
+	})
+
+	t.Run("bad signature", func(t *testing.T) {
+		// Verifying against an unrelated key should fail, exactly as it
+		// would if the certificate's signature bytes were tampered with.
+		otherPub, _, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("ed25519.GenerateKey: %v", err)
+		}
+
+		if err := VerifyEd25519Cert(cert, otherPub); !errors.Is(err, ErrBadSignature) {
+			t.Fatalf("expected ErrBadSignature, got %v", err)
+		}
+	})
+}